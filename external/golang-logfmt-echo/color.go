@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorKey    = "\x1b[36m"
+	colorValue  = "\x1b[32m"
+	colorSevere = "\x1b[1;31m"
+)
+
+// colorMode is the value of the -color flag. It follows the usual
+// auto/always/never convention. It is not a boolean flag, because a bare
+// -color would take a following input file name as the mode.
+type colorMode string
+
+func (m *colorMode) String() string { return string(*m) }
+
+func (m *colorMode) Set(s string) error {
+	switch s {
+	case "auto", "always", "never":
+		*m = colorMode(s)
+	default:
+		return errors.New("must be auto, always or never")
+	}
+	return nil
+}
+
+// enabled reports whether output written to w should be colored.
+func (m colorMode) enabled(w io.Writer) bool {
+	switch m {
+	case "always":
+		return true
+	case "auto":
+		return isTerminal(w)
+	}
+	return false
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps the already encoded key and value in escape codes. If
+// severe is set, the pair is highlighted.
+func colorize(key, value string, severe bool) (string, string) {
+	if severe {
+		return colorSevere + key + colorReset, colorSevere + value + colorReset
+	}
+	return colorKey + key + colorReset, colorValue + value + colorReset
+}
+
+// isSevere reports whether a pair reports a problem, such as level=error. It
+// takes the decoded key and value, since -always-quote changes their
+// spelling.
+func isSevere(key, value string) bool {
+	switch key {
+	case "level", "error", "err":
+	default:
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "error", "fatal", "warn", "warning":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColorAuto(t *testing.T) {
+	input := "level=error msg=\"disk full\"\n"
+
+	for _, args := range [][]string{nil, {"-color", "auto"}, {"-color=auto"}, {"-color=auto", "-pretty"}} {
+		stdout, stderr, code := echo(t, args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", args, code, stderr)
+		}
+		if strings.Contains(stdout, "\x1b") {
+			t.Errorf("%v: got escape codes in %q", args, stdout)
+		}
+	}
+}
+
+func TestColorAlways(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{
+			nil,
+			"level=error msg=\"disk full\"",
+			"\x1b[1;31mlevel\x1b[0m=\x1b[1;31merror\x1b[0m \x1b[36mmsg\x1b[0m=\x1b[32m\"disk full\"\x1b[0m",
+		},
+		{
+			nil,
+			"level=info err=",
+			"\x1b[36mlevel\x1b[0m=\x1b[32minfo\x1b[0m \x1b[36merr\x1b[0m=\x1b[32m\x1b[0m",
+		},
+		{
+			[]string{"-always-quote"},
+			"level=error msg=ok",
+			"\x1b[1;31mlevel\x1b[0m=\x1b[1;31m\"error\"\x1b[0m \x1b[36mmsg\x1b[0m=\x1b[32m\"ok\"\x1b[0m",
+		},
+	} {
+		stdout, stderr, code := echo(t, append([]string{"-color=always"}, tt.args...), tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("got %q, want %q", stdout, tt.want+"\n")
+		}
+	}
+}
+
+func TestColorSeparateValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("a=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := echo(t, []string{"-color", "always", path}, "")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "\x1b[36ma\x1b[0m=\x1b[32m1\x1b[0m\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestColorInvalid(t *testing.T) {
+	for _, mode := range []string{"sometimes", "true", "false"} {
+		_, stderr, code := echo(t, []string{"-color=" + mode}, "")
+		if code != 2 {
+			t.Fatalf("%s: exit code %d, want 2", mode, code)
+		}
+		if !strings.Contains(stderr, "must be auto, always or never") {
+			t.Errorf("%s: got %q", mode, stderr)
+		}
+	}
+}
+
+func TestColorDefault(t *testing.T) {
+	// Without a terminal to write to, the help text is all that shows the
+	// default.
+	_, stderr, _ := echo(t, []string{"-h"}, "")
+	if !strings.Contains(stderr, "colorize output: auto, always or never (default auto)") {
+		t.Errorf("got %q", stderr)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+)
+
+// A csvEncoder writes records as rows of a CSV table with a fixed set of
+// columns. Pairs without a column are dropped, or collected as a JSON
+// object in a trailing "extra" column if extra is set.
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+	extra   bool
+}
+
+// newCSVEncoder returns a csvEncoder writing to w.
+func newCSVEncoder(w io.Writer, columns []string, extra bool) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w), columns: columns, extra: extra}
+}
+
+// writeHeader writes the header row, which names the columns.
+func (e *csvEncoder) writeHeader() error {
+	header := e.columns
+	if e.extra {
+		header = append(header[:len(header):len(header)], "extra")
+	}
+	if err := e.w.Write(header); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// encode writes pairs as a single row. A column whose key is missing from
+// the record is left empty. If a key is repeated, its column gets the first
+// value and the others are treated like pairs without a column.
+func (e *csvEncoder) encode(pairs [][2]string) error {
+	row := make([]string, len(e.columns), len(e.columns)+1)
+	filled := make([]bool, len(e.columns))
+	var rest [][2]string
+
+	for _, kv := range pairs {
+		if i := indexOf(e.columns, kv[0]); i >= 0 && !filled[i] {
+			row[i], filled[i] = kv[1], true
+		} else {
+			rest = append(rest, kv)
+		}
+	}
+
+	if e.extra {
+		var extra string
+		if len(rest) > 0 {
+			var buf bytes.Buffer
+			if err := encodeJSON(&buf, rest, false, false); err != nil {
+				return err
+			}
+			extra = string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+		}
+		row = append(row, extra)
+	}
+
+	if err := e.w.Write(row); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func indexOf(columns []string, key string) int {
+	for i, column := range columns {
+		if column == key {
+			return i
+		}
+	}
+	return -1
+}
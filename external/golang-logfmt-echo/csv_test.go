@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestToCSV(t *testing.T) {
+	input := "at=INFO msg=\"hello, world\" user=5\nat=WARN extra=1 other=\"x y\"\nmsg=\"say \\\"hi\\\"\" at=ERROR at=FATAL\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-columns", "at,msg"},
+			"at,msg\n" +
+				"INFO,\"hello, world\"\n" +
+				"WARN,\n" +
+				"ERROR,\"say \"\"hi\"\"\"\n",
+		},
+		{
+			[]string{"-columns", "msg,at", "-csv-extra"},
+			"msg,at,extra\n" +
+				"\"hello, world\",INFO,\"{\"\"user\"\":\"\"5\"\"}\"\n" +
+				",WARN,\"{\"\"extra\"\":\"\"1\"\",\"\"other\"\":\"\"x y\"\"}\"\n" +
+				"\"say \"\"hi\"\"\",ERROR,\"{\"\"at\"\":\"\"FATAL\"\"}\"\n",
+		},
+		{
+			[]string{"-columns", "at", "-where", "at=none"},
+			"at\n",
+		},
+	} {
+		stdout, stderr, code := echo(t, append([]string{"-to", "csv"}, tt.args...), input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got:\n%s\nwant:\n%s", tt.args, stdout, tt.want)
+		}
+	}
+
+	if _, _, code := echo(t, []string{"-to", "csv"}, input); code != 2 {
+		t.Errorf("exit code %d without -columns, want 2", code)
+	}
+}
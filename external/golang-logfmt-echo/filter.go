@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// keyList is the value of a flag holding a comma separated list of keys.
+// Repeating the flag extends the list.
+type keyList map[string]bool
+
+func (l keyList) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (l keyList) Set(s string) error {
+	for _, key := range strings.Split(s, ",") {
+		if key != "" {
+			l[key] = true
+		}
+	}
+	return nil
+}
+
+// filterKeys drops the pairs whose key is not in include, unless include is
+// empty, as well as those whose key is in exclude. Exclusion wins when a key
+// is in both lists.
+func filterKeys(pairs [][2]string, include, exclude keyList) [][2]string {
+	if len(include) == 0 && len(exclude) == 0 {
+		return pairs
+	}
+
+	filtered := pairs[:0]
+	for _, kv := range pairs {
+		if len(include) > 0 && !include[kv[0]] || exclude[kv[0]] {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+// omitEmpty drops the pairs whose value is empty. Values such as 0 or false
+// are kept, and so are bare keys, which are flags rather than empty values.
+func omitEmpty(pairs [][2]string) [][2]string {
+	kept := pairs[:0]
+	for _, kv := range pairs {
+		if kv[1] != "" {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// A condition matches records holding a pair with the given key whose value
+// equals value, or matches re if it is set.
+type condition struct {
+	key   string
+	value string
+	re    *regexp.Regexp
+}
+
+func (c condition) match(pairs [][2]string) bool {
+	for _, kv := range pairs {
+		if kv[0] != c.key {
+			continue
+		}
+		value := kv[1]
+		if value == noValue {
+			value = ""
+		}
+		if c.re != nil && c.re.MatchString(value) || c.re == nil && value == c.value {
+			return true
+		}
+	}
+	return false
+}
+
+// whereFlag is the value of the -where and -where-re flags, which both
+// append to the same list of conditions.
+type whereFlag struct {
+	conditions *[]condition
+	regexp     bool
+}
+
+func (f whereFlag) String() string { return "" }
+
+func (f whereFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return errors.New("must be of the form key=value")
+	}
+
+	c := condition{key: key, value: value}
+	if f.regexp {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		c.re = re
+	}
+	*f.conditions = append(*f.conditions, c)
+	return nil
+}
+
+// matchAll reports whether pairs satisfy every condition.
+func matchAll(pairs [][2]string, conditions []condition) bool {
+	for _, c := range conditions {
+		if !c.match(pairs) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFilterKeys(t *testing.T) {
+	input := "at=INFO msg=hello user=5 msg=again\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-include", "msg"}, "msg=hello msg=again\n"},
+		{[]string{"-include", "at,user"}, "at=INFO user=5\n"},
+		{[]string{"-include", "at", "-include", "user"}, "at=INFO user=5\n"},
+		{[]string{"-exclude", "msg"}, "at=INFO user=5\n"},
+		{[]string{"-include", "at,msg", "-exclude", "msg"}, "at=INFO\n"},
+		{[]string{"-exclude", "at,msg,user"}, "\n"},
+		{[]string{"-exclude", "at,msg,user", "-drop-empty"}, ""},
+		{[]string{"-include", "missing"}, "\n"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+func TestDropEmpty(t *testing.T) {
+	stdout, stderr, code := echo(t, []string{"-exclude", "debug", "-drop-empty"}, "a=1\ndebug=x\n\nb=2\n")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1\nb=2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{nil, `a= b="" c=0 d=false e`, `a= b= c=0 d=false e`},
+		{[]string{"-omit-empty"}, `a= b="" c=0 d=false e`, "c=0 d=false e"},
+		{[]string{"-omit-empty", "-from", "json"}, `{"a":null,"b":"","c":0,"d":false,"e":[]}`, "c=0 d=false"},
+		{[]string{"-omit-empty", "-to", "json"}, `a= c=0`, `{"c":"0"}`},
+		{[]string{"-omit-empty", "-exclude", "c", "-drop-empty"}, `a= c=0`, ""},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		want := tt.want + "\n"
+		if tt.want == "" {
+			want = ""
+		}
+		if stdout != want {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, want)
+		}
+	}
+}
+
+func TestWhere(t *testing.T) {
+	input := "level=info msg=started\nlevel=error msg=\"disk full\" host=a\nlevel=error msg=timeout host=b\nmsg=bare\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-where", "level=error"}, "level=error msg=\"disk full\" host=a\nlevel=error msg=timeout host=b\n"},
+		{[]string{"-where", "host=b"}, "level=error msg=timeout host=b\n"},
+		{[]string{"-where", "level="}, ""},
+		{[]string{"-where", "missing=x"}, ""},
+		{[]string{"-where-re", "msg=^(start|time)"}, "level=info msg=started\nlevel=error msg=timeout host=b\n"},
+		{[]string{"-where-re", "level=."}, "level=info msg=started\nlevel=error msg=\"disk full\" host=a\nlevel=error msg=timeout host=b\n"},
+		{[]string{"-where", "level=error", "-where-re", "msg=\\s"}, "level=error msg=\"disk full\" host=a\n"},
+		{[]string{"-where", "level=error", "-where", "host=c"}, ""},
+		{[]string{"-where", "level=error", "-include", "host"}, "host=a\nhost=b\n"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+func TestWhereInvalid(t *testing.T) {
+	for _, args := range [][]string{{"-where", "level"}, {"-where", "=x"}, {"-where-re", "msg=("}} {
+		if _, _, code := echo(t, args, ""); code != 2 {
+			t.Errorf("%v: exit code %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// gunzip returns a reader for the contents of r, decompressing them when r
+// starts with the gzip magic bytes and passing them through otherwise.
+func gunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// Input shorter than the magic bytes cannot be gzip; any read error
+	// resurfaces on the next read.
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// pollInterval is how long a follower waits at the end of its file before
+// checking for new data.
+var pollInterval = 250 * time.Millisecond
+
+// A follower reads a file from the start and, like tail -f, waits for more
+// data at its end instead of reporting io.EOF. If the file shrinks it is
+// read again from the start, and if it is replaced, for example by log
+// rotation, the new file is opened once the old one has been read. Read
+// returns io.EOF once the context is done.
+//
+// Truncation is detected by the file being shorter than what was read from
+// it. A file that is truncated and rewritten to at least that length
+// within one poll interval is not read again from the start.
+type follower struct {
+	ctx    context.Context
+	path   string
+	file   *os.File
+	offset int64
+}
+
+func newFollower(ctx context.Context, path string) (*follower, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &follower{ctx: ctx, path: path, file: file}, nil
+}
+
+func (f *follower) Read(p []byte) (int, error) {
+	// Stop even if the file never runs out of data.
+	if f.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	for {
+		n, err := f.file.Read(p)
+		f.offset += int64(n)
+		if n > 0 || err != nil && err != io.EOF {
+			return n, err
+		}
+
+		if err := f.reopen(); err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// reopen rewinds the file if it was truncated and switches to a new file if
+// the path now points to one. A path that vanished is waited for.
+func (f *follower) reopen() error {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	current, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !os.SameFile(info, current) {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		f.file.Close()
+		f.file, f.offset = file, 0
+		return nil
+	}
+	if info.Size() < f.offset {
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		f.offset = 0
+	}
+	return nil
+}
+
+func (f *follower) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGzipInput(t *testing.T) {
+	input := "at=INFO msg=\"hello world\"\nat=WARN msg=bye\n"
+
+	plain, stderr, code := echo(t, nil, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write([]byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := echo(t, nil, compressed.String())
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if stdout != plain {
+		t.Errorf("got %q, want %q", stdout, plain)
+	}
+}
+
+func TestGzipCorrupt(t *testing.T) {
+	_, stderr, code := echo(t, nil, "\x1f\x8b garbage\n")
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if stderr == "" {
+		t.Error("got no error message")
+	}
+}
+
+func TestShortInput(t *testing.T) {
+	for _, input := range []string{"", "a", "\x1f"} {
+		if _, stderr, code := echo(t, nil, input); code != 0 {
+			t.Errorf("%q: exit code %d: %s", input, code, stderr)
+		}
+	}
+}
+
+func TestInputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("a=1\nb=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := echo(t, []string{path}, "ignored=stdin\n")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1\nb=2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	for _, args := range [][]string{{"-follow"}, {path, path}} {
+		if _, _, code := echo(t, args, ""); code != 2 {
+			t.Errorf("%v: exit code %d, want 2", args, code)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be read while run writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFollow(t *testing.T) {
+	defer func(prev time.Duration) { pollInterval = prev }(pollInterval)
+	pollInterval = 5 * time.Millisecond
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("k=00\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var stdout, stderr syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- run(ctx, []string{"-follow", "-where-re", "k=.", path}, strings.NewReader(""), &stdout, &stderr)
+	}()
+
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for stdout.String() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %q, want %q", stdout.String(), want)
+			}
+			time.Sleep(pollInterval)
+		}
+	}
+	write := func(flag int, data string) {
+		t.Helper()
+		f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor("k=00\n")
+
+	// The truncated file has to be shorter than what was read from it, or
+	// the truncation goes unnoticed.
+	write(os.O_TRUNC, "k=1\n")
+	waitFor("k=00\nk=1\n")
+
+	// A partial line is held back until its newline arrives.
+	write(os.O_APPEND, "skip=me\nk=2")
+	time.Sleep(20 * pollInterval)
+	waitFor("k=00\nk=1\n")
+	write(os.O_APPEND, "\n")
+	waitFor("k=00\nk=1\nk=2\n")
+
+	write(os.O_TRUNC, "k=3\n")
+	waitFor("k=00\nk=1\nk=2\nk=3\n")
+
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	write(os.O_TRUNC, "k=4\n")
+	waitFor("k=00\nk=1\nk=2\nk=3\nk=4\n")
+
+	cancel()
+	if code := <-done; code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decodeJSON parses line as a JSON object and flattens it into logfmt
+// pairs. Nested objects contribute dotted keys and arrays are joined with
+// commas. Keys are emitted in lexicographic order since JSON objects carry
+// no meaningful order.
+func decodeJSON(line string) ([][2]string, error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, errors.New("not a JSON object")
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+
+	return flatten(object, ".")
+}
+
+// flatten turns a decoded JSON object into logfmt pairs. The keys of nested
+// objects are joined with sep, so {"a":{"b":1}} becomes a.b=1. Keys are
+// sorted at every level. Two values ending up with the same key, as in
+// {"a":{"b":1},"a.b":2}, are an error, and so are keys that are not valid
+// logfmt keys and empty nested objects, which would have no key at all.
+func flatten(object map[string]interface{}, sep string) ([][2]string, error) {
+	var pairs [][2]string
+	if err := flattenJSON(&pairs, "", object, sep); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(pairs))
+	for _, kv := range pairs {
+		if seen[kv[0]] {
+			return nil, fmt.Errorf("key %q occurs more than once", kv[0])
+		}
+		seen[kv[0]] = true
+	}
+	return pairs, nil
+}
+
+func flattenJSON(pairs *[][2]string, prefix string, object map[string]interface{}, sep string) error {
+	if len(object) == 0 && prefix != "" {
+		return fmt.Errorf("key %q holds an empty object", prefix)
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := key
+		if prefix != "" {
+			name = prefix + sep + key
+		}
+		switch v := object[key].(type) {
+		case map[string]interface{}:
+			if err := flattenJSON(pairs, name, v, sep); err != nil {
+				return err
+			}
+		default:
+			if !validKey(name) {
+				return fmt.Errorf("key %q is not a valid logfmt key", name)
+			}
+			*pairs = append(*pairs, [2]string{name, formatJSON(v)})
+		}
+	}
+	return nil
+}
+
+// A nestError reports a key that is both a value and the parent of other
+// keys, as in a=1 a.b=2, so it cannot be nested.
+type nestError struct {
+	key string
+}
+
+func (e *nestError) Error() string {
+	return fmt.Sprintf("key %q is both a value and an object", e.key)
+}
+
+// unflatten is the inverse of flatten: it splits the keys of object at sep
+// and nests the values accordingly. A key that is both a value and the
+// parent of other keys is a *nestError.
+func unflatten(object map[string]interface{}, sep string) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	// Sorting reports the same conflict no matter how the map is ordered.
+	sort.Strings(keys)
+
+	nested := make(map[string]interface{})
+	for _, key := range keys {
+		path := strings.Split(key, sep)
+		parent := nested
+		for i, name := range path[:len(path)-1] {
+			switch child := parent[name].(type) {
+			case nil:
+				m := make(map[string]interface{})
+				parent[name] = m
+				parent = m
+			case map[string]interface{}:
+				parent = child
+			default:
+				return nil, &nestError{key: strings.Join(path[:i+1], sep)}
+			}
+		}
+		name := path[len(path)-1]
+		if _, ok := parent[name]; ok {
+			return nil, &nestError{key: key}
+		}
+		parent[name] = object[key]
+	}
+	return nested, nil
+}
+
+// formatJSON renders a decoded JSON value as a logfmt value.
+func formatJSON(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return formatNumber(v)
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			elems[i] = formatJSON(elem)
+		}
+		return strings.Join(elems, ",")
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// formatNumber spells out numbers written in exponent notation, as long as
+// the result stays reasonably short.
+func formatNumber(n json.Number) string {
+	s := string(n)
+	if !strings.ContainsAny(s, "eE") {
+		return s
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.Abs(f) >= 1e21 || (f != 0 && math.Abs(f) < 1e-6) {
+		return s
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// jsonNumber matches the number grammar of RFC 8259. Values such as "00123"
+// or "+1" are not valid JSON numbers and stay strings under type inference.
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// encodeJSON writes pairs to w as a compact JSON object followed by a
+// newline. Repeated keys are collected into an array, and object keys are
+// sorted by encoding/json. If inferTypes is true, values that look like
+// numbers or booleans are emitted as such. If nest is true, dotted keys are
+// turned into nested objects.
+func encodeJSON(w io.Writer, pairs [][2]string, inferTypes, nest bool) error {
+	object := make(map[string]interface{}, len(pairs))
+	for _, kv := range pairs {
+		var value interface{} = kv[1]
+		if inferTypes {
+			value = inferType(kv[1])
+		}
+		switch prev := object[kv[0]].(type) {
+		case nil:
+			object[kv[0]] = value
+		case []interface{}:
+			object[kv[0]] = append(prev, value)
+		default:
+			object[kv[0]] = []interface{}{prev, value}
+		}
+	}
+
+	if nest {
+		var err error
+		if object, err = unflatten(object, "."); err != nil {
+			return err
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(object)
+}
+
+func inferType(value string) interface{} {
+	switch {
+	case value == "true":
+		return true
+	case value == "false":
+		return false
+	case jsonNumber.MatchString(value):
+		// json.Number keeps the original spelling, so "1.0" stays 1.0.
+		return json.Number(value)
+	}
+	return value
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromJSON(t *testing.T) {
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{`{"msg":"hello","level":"info"}`, "level=info msg=hello"},
+		{`{"user":{"id":5,"address":{"city":"Berlin"}}}`, "user.address.city=Berlin user.id=5"},
+		{`{"tags":["a","b",3],"empty":[]}`, "empty= tags=a,b,3"},
+		{`{"msg":"grüße 🌍","name":"日本"}`, `msg="grüße 🌍" name=日本`},
+		{`{"ok":true,"err":null}`, "err= ok=true"},
+		{`{"big":1e3,"small":1.5E-3,"huge":1e300,"pi":3.14}`, "big=1000 huge=1e300 pi=3.14 small=0.0015"},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			stdout, stderr, code := echo(t, []string{"-from", "json"}, tt.input+"\n")
+			if code != 0 {
+				t.Fatalf("exit code %d: %s", code, stderr)
+			}
+			if stdout != tt.want+"\n" {
+				t.Errorf("got %q, want %q", stdout, tt.want+"\n")
+			}
+		})
+	}
+}
+
+func TestFromJSONInvalid(t *testing.T) {
+	input := "{\"a\":1}\nnot json\n[1,2]\n{\"a\":1} {\"b\":2}\n" +
+		"{\"my key\":1,\"mykey\":2}\n{\"\":1}\n{\"a\":{}}\n{\"b\":2}\n"
+
+	stdout, stderr, code := echo(t, []string{"-from", "json"}, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1\nb=2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	for _, line := range []string{"line 2", "line 3", "line 4", "line 5", "line 6", "line 7"} {
+		if !strings.Contains(stderr, "error: "+line+":") {
+			t.Errorf("%s not reported in %q", line, stderr)
+		}
+	}
+
+	stdout, _, code = echo(t, []string{"-from", "json", "-strict"}, input)
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if want := "a=1\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{nil, "b=2 a=1 msg=\"hello <world>\"", `{"a":"1","b":"2","msg":"hello <world>"}`},
+		{nil, "tag=x tag=y tag=z n=1", `{"n":"1","tag":["x","y","z"]}`},
+		{nil, "bare empty=", `{"bare":"","empty":""}`},
+		{[]string{"-infer-types"}, "i=42 neg=-7 f=1.0 e=2.5e3 t=true f2=false", `{"e":2.5e3,"f":1.0,"f2":false,"i":42,"neg":-7,"t":true}`},
+		{[]string{"-infer-types"}, "zip=00123 plus=+1 dot=.5 True=True empty= word=null", `{"True":"True","dot":".5","empty":"","plus":"+1","word":"null","zip":"00123"}`},
+		{[]string{"-infer-types"}, "n=1 n=two", `{"n":[1,"two"]}`},
+		{[]string{"-nest"}, "user.address.city=Berlin user.id=5 msg=hi", `{"msg":"hi","user":{"address":{"city":"Berlin"},"id":"5"}}`},
+		{[]string{"-nest", "-infer-types"}, "a.b=1 a.b=2", `{"a":{"b":[1,2]}}`},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			stdout, stderr, code := echo(t, append([]string{"-to", "json"}, tt.args...), tt.input+"\n")
+			if code != 0 {
+				t.Fatalf("exit code %d: %s", code, stderr)
+			}
+			if stdout != tt.want+"\n" {
+				t.Errorf("got %q, want %q", stdout, tt.want+"\n")
+			}
+		})
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	object := map[string]interface{}{
+		"msg": "hello",
+		"user": map[string]interface{}{
+			"id": json.Number("5"),
+			"address": map[string]interface{}{
+				"city": "Berlin",
+				"zip":  "10115",
+			},
+		},
+	}
+	want := [][2]string{
+		{"msg", "hello"},
+		{"user/address/city", "Berlin"},
+		{"user/address/zip", "10115"},
+		{"user/id", "5"},
+	}
+
+	pairs, err := flatten(object, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("got %q, want %q", pairs, want)
+	}
+
+	object["user/id"] = "6"
+	if _, err := flatten(object, "/"); err == nil || err.Error() != `key "user/id" occurs more than once` {
+		t.Errorf("got error %v for a duplicate key", err)
+	}
+
+	for _, tt := range []struct {
+		object map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"my key": "1", "mykey": "2"}, `key "my key" is not a valid logfmt key`},
+		{map[string]interface{}{"": "1"}, `key "" is not a valid logfmt key`},
+		{map[string]interface{}{"a": map[string]interface{}{}}, `key "a" holds an empty object`},
+	} {
+		if _, err := flatten(tt.object, "/"); err == nil || err.Error() != tt.want {
+			t.Errorf("%v: got error %v, want %s", tt.object, err, tt.want)
+		}
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+	object := map[string]interface{}{
+		"msg":               "hello",
+		"user.id":           "5",
+		"user.address.city": "Berlin",
+		"user.address.zip":  "10115",
+	}
+	want := map[string]interface{}{
+		"msg": "hello",
+		"user": map[string]interface{}{
+			"id": "5",
+			"address": map[string]interface{}{
+				"city": "Berlin",
+				"zip":  "10115",
+			},
+		},
+	}
+
+	nested, err := unflatten(object, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nested, want) {
+		t.Errorf("got %v, want %v", nested, want)
+	}
+
+	object["user.address"] = "Berlin"
+	if _, err := unflatten(object, "."); err == nil || err.Error() != `key "user.address" is both a value and an object` {
+		t.Errorf("got error %v for a conflicting key", err)
+	}
+}
+
+func TestNestRoundTrip(t *testing.T) {
+	input := `{"level":"info","user":{"address":{"city":"Berlin","zip":"10115"},"name":"Ada"}}` + "\n"
+
+	stdout, stderr, code := echo(t, []string{"-from", "json", "-to", "json", "-nest"}, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if stdout != input {
+		t.Errorf("got %q, want %q", stdout, input)
+	}
+
+	// A conflict is reported and the record skipped, like invalid JSON.
+	input = "a=1 a.b=2\nb=3\n"
+	stdout, stderr, code = echo(t, []string{"-to", "json", "-nest"}, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := `{"b":"3"}` + "\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if want := "error: line 1: key \"a\" is both a value and an object\n"; stderr != want {
+		t.Errorf("got error output %q, want %q", stderr, want)
+	}
+
+	stdout, _, code = echo(t, []string{"-to", "json", "-nest", "-strict"}, input)
+	if code != 1 || stdout != "" {
+		t.Errorf("-strict: exit code %d, output %q", code, stdout)
+	}
+}
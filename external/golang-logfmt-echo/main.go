@@ -1,44 +1,574 @@
-
+// Command golang-logfmt-echo reads logfmt records from stdin, decodes them
+// with github.com/go-logfmt/logfmt and writes them back to stdout. The test
+// suite uses it to verify that logfmter output is understood by a third
+// party implementation.
+//
+// When converting logfmt to logfmt, the pairs of a record are written in
+// input order. Only -sort and -reverse reorder them, and -add-ts puts its
+// timestamp first. Other formats have their own order: -from json emits
+// flattened keys sorted, -to json sorts object keys and -to csv follows
+// -columns.
 package main
 
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-logfmt/logfmt"
 )
 
+// now is the clock used by -add-ts. Tests replace it.
+var now = time.Now
+
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run reads from stdin, or from the file named by the only positional
+// argument. With -follow it keeps reading until ctx is done.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("golang-logfmt-echo", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	follow := flags.Bool("follow", false, "keep reading the file given as argument as it grows, like tail -f")
+	sortKeys := flags.Bool("sort", false, "emit the keys of each record in lexicographic order")
+	reverse := flags.Bool("reverse", false, "emit the keys of each record in reverse order, after -sort")
+	include, exclude := keyList{}, keyList{}
+	flags.Var(include, "include", "comma separated `keys` to keep, all others are dropped")
+	flags.Var(exclude, "exclude", "comma separated `keys` to drop")
+	lowerKeys := flags.Bool("lower-keys", false, "lowercase all keys before any other processing")
+	var keyReplace replaceList
+	flags.Var(&keyReplace, "key-replace", "replace `old=new` in all keys before any other processing, may be repeated")
+	renames := renameList{}
+	flags.Var(renames, "rename", "rename key `old=new` after -lower-keys and -key-replace, may be repeated")
+	duplicates := flags.String("duplicates", "all", "how to treat repeated keys in a record: first, last or all")
+	var where []condition
+	flags.Var(whereFlag{conditions: &where}, "where", "only emit records where `key=value`, may be repeated")
+	flags.Var(whereFlag{conditions: &where, regexp: true}, "where-re", "only emit records where the value of key matches a regular expression, given as `key=regexp`")
+	addTS := flags.Bool("add-ts", false, "prepend the current time in RFC3339 format to every record")
+	tsKey := flags.String("ts-key", "ts", "`key` of the timestamp added by -add-ts")
+	forceTS := flags.Bool("force-ts", false, "with -add-ts, replace timestamps already present in a record")
+	maxValueLen := flags.Int("max-value-len", 0, "truncate values longer than `bytes`, 0 means no limit")
+	truncateMarker := flags.String("truncate-marker", "…", "`text` appended to truncated values")
+	omitEmpty := flags.Bool("omit-empty", false, "drop pairs with an empty value, such as key= or a JSON null")
+	dropEmpty := flags.Bool("drop-empty", false, "drop records that end up without any pairs")
+	skipInvalid := flags.Bool("skip-invalid", false, "skip malformed pairs instead of aborting")
+	printStats := flags.Bool("stats", false, "print a summary of the input at EOF instead of echoing it")
+	statsKey := flags.String("stats-key", "", "with -stats, count the values of `key`")
+	maxPairs := flags.Int("max-pairs", 0, "fail records holding more than `n` pairs, 0 means no limit")
+	validateUTF8 := flags.Bool("validate-utf8", false, "reject values that are not valid UTF-8")
+	from := flags.String("from", "logfmt", "input `format`: logfmt or json")
+	to := flags.String("to", "logfmt", "output `format`: logfmt, json or csv")
+	columns := flags.String("columns", "", "with -to csv, the comma separated `keys` to use as columns")
+	csvExtra := flags.Bool("csv-extra", false, "with -to csv, collect pairs without a column as JSON in a trailing extra column")
+	crlf := flags.Bool("crlf", false, "terminate output lines with \\r\\n instead of \\n")
+	alwaysQuote := flags.Bool("always-quote", false, "quote every value, not just those that need it")
+	pretty := flags.Bool("pretty", false, "print each pair on its own line, aligned within the record")
+	color := colorMode("auto")
+	flags.Var(&color, "color", "colorize output: auto, always or never")
+	inferTypes := flags.Bool("infer-types", false, "with -to json, emit integers, floats and booleans as JSON values")
+	nest := flags.Bool("nest", false, "with -to json, turn dotted keys into nested objects")
+	strict := flags.Bool("strict", false, "abort on input lines that are not valid JSON and on records -nest cannot nest, instead of skipping them")
+	recordsPerLine := flags.String("records-per-line", "1", "number of logfmt records on each input line: 1 or many")
+	recordSep := flags.String("record-sep", "\x1e", "with -records-per-line=many, the `separator` between records")
+	maxLine := flags.Int("max-line", 16<<20, "maximum length of an input line in `bytes`, not counting its terminator")
+	bufSize := flags.Int("buf-size", 4<<10, "initial size of the line buffer in `bytes`, it grows up to -max-line as needed")
+	parallel := flags.Int("parallel", 1, "process lines with `n` goroutines, output stays in input order")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintln(stderr, "error: at most one input file may be given")
+		return 2
+	}
+	if *follow && flags.NArg() == 0 {
+		fmt.Fprintln(stderr, "error: -follow requires an input file")
+		return 2
+	}
+	if *from != "logfmt" && *from != "json" {
+		fmt.Fprintf(stderr, "error: unknown input format %q\n", *from)
+		return 2
+	}
+	if *to != "logfmt" && *to != "json" && *to != "csv" {
+		fmt.Fprintf(stderr, "error: unknown output format %q\n", *to)
+		return 2
+	}
+	if *recordsPerLine != "1" && *recordsPerLine != "many" {
+		fmt.Fprintf(stderr, "error: -records-per-line must be 1 or many, not %q\n", *recordsPerLine)
+		return 2
+	}
+	if *recordSep == "" {
+		fmt.Fprintln(stderr, "error: -record-sep must not be empty")
+		return 2
+	}
+	if *duplicates != "first" && *duplicates != "last" && *duplicates != "all" {
+		fmt.Fprintf(stderr, "error: -duplicates must be first, last or all, not %q\n", *duplicates)
+		return 2
+	}
+	if *to == "csv" && *columns == "" {
+		fmt.Fprintln(stderr, "error: -to csv requires -columns")
+		return 2
+	}
+	if *nest && *to != "json" {
+		fmt.Fprintln(stderr, "error: -nest requires JSON output")
+		return 2
+	}
+	if !validKey(*tsKey) {
+		fmt.Fprintf(stderr, "error: -ts-key %q is not a valid logfmt key\n", *tsKey)
+		return 2
+	}
+	if *maxValueLen < 0 {
+		fmt.Fprintln(stderr, "error: -max-value-len must not be negative")
+		return 2
+	}
+	if *maxPairs < 0 {
+		fmt.Fprintln(stderr, "error: -max-pairs must not be negative")
+		return 2
+	}
+	if *maxLine < 1 {
+		fmt.Fprintln(stderr, "error: -max-line must be at least 1")
+		return 2
+	}
+	if *bufSize < 0 {
+		fmt.Fprintln(stderr, "error: -buf-size must not be negative")
+		return 2
+	}
+	if *parallel < 1 {
+		fmt.Fprintln(stderr, "error: -parallel must be at least 1")
+		return 2
+	}
+	if *pretty && *to != "logfmt" {
+		fmt.Fprintln(stderr, "error: -pretty requires logfmt output")
+		return 2
+	}
+
+	t := &transforms{
+		lowerKeys:      *lowerKeys,
+		renames:        renames,
+		duplicates:     *duplicates,
+		where:          where,
+		include:        include,
+		exclude:        exclude,
+		omitEmpty:      *omitEmpty,
+		dropEmpty:      *dropEmpty,
+		addTS:          *addTS,
+		tsKey:          *tsKey,
+		forceTS:        *forceTS,
+		maxValueLen:    *maxValueLen,
+		truncateMarker: *truncateMarker,
+		sortKeys:       *sortKeys,
+		reverse:        *reverse,
+	}
+	if len(keyReplace) > 0 {
+		t.replacer = strings.NewReplacer(keyReplace...)
+	}
+
+	var stop context.CancelFunc
+	if *follow {
+		// Ctrl-C ends -follow like EOF ends other input. Without -follow
+		// the default handling applies, and once ctx is done a second
+		// Ctrl-C kills the process.
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		context.AfterFunc(ctx, stop)
+	}
+
+	var input io.Reader
+	switch {
+	case *follow:
+		f, err := newFollower(ctx, flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		// A file that is still being written is never gzip compressed.
+		input = f
+	case flags.NArg() == 1:
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		stdin = f
+		fallthrough
+	default:
+		var err error
+		if input, err = gunzip(stdin); err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return 1
+		}
+	}
+
+	// Whether stdout is a terminal has to be checked before it is wrapped.
+	colored := color.enabled(stdout)
+	if *crlf {
+		stdout = crlfWriter{stdout}
+	}
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+
+	tc := newTranscoder(input, out, t.apply)
+	tc.errw = stderr
+	if *follow {
+		tc.flush = out.Flush
+		tc.cancel = stop
+	}
+	tc.from = *from
+	tc.manyRecords = *recordsPerLine == "many"
+	tc.recordSep = *recordSep
+	tc.decodeOpts = decodeOptions{
+		lenient:      *skipInvalid,
+		validateUTF8: *validateUTF8,
+		maxPairs:     *maxPairs,
+	}
+	tc.strict = *strict
+	tc.bufSize = *bufSize
+	tc.maxLine = *maxLine
+	tc.parallel = *parallel
+	tc.encodeOpts = encodeOptions{
+		to:          *to,
+		printStats:  *printStats,
+		statsKey:    *statsKey,
+		columns:     strings.Split(*columns, ","),
+		csvExtra:    *csvExtra,
+		inferTypes:  *inferTypes,
+		nest:        *nest,
+		pretty:      *pretty,
+		colored:     colored,
+		alwaysQuote: *alwaysQuote,
+	}
+
+	if err := tc.Run(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	if err := out.Flush(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// scanLines is a bufio.SplitFunc that splits input into lines terminated by
+// "\n", "\r\n" or a lone "\r". The terminator is not part of the line.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		switch {
+		case data[i] == '\n':
+			return i + 1, data[:i], nil
+		case i+1 < len(data) && data[i+1] == '\n':
+			return i + 2, data[:i], nil
+		case i+1 < len(data) || atEOF:
+			return i + 1, data[:i], nil
+		}
+		// Wait for the next byte to tell "\r" from "\r\n".
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// splitRecords splits a line holding several logfmt records at every sep
+// that is not inside a quoted value. The upstream decoder treats a whole
+// line as a single record, so this has to happen before decoding.
+func splitRecords(line, sep string) []string {
+	var records []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(line[i:], sep):
+			records = append(records, line[start:i])
+			start = i + len(sep)
+			i = start - 1
+		}
+	}
+	return append(records, line[start:])
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		decoder := logfmt.NewDecoder(strings.NewReader(line))
+// decodeOptions controls how strictly decode treats its input.
+type decodeOptions struct {
+	// lenient drops malformed pairs instead of failing the record.
+	lenient bool
+	// validateUTF8 treats values that are not valid UTF-8 as malformed.
+	validateUTF8 bool
+	// maxPairs, if positive, fails records holding more pairs than that.
+	// Decoding stops at the first pair over the limit.
+	maxPairs int
+	// quoted, if non-nil, receives the pairs whose value was quoted in the
+	// line, even though the upstream decoder removes the quotes.
+	quoted map[[2]string]bool
+}
 
-		var pairs [][2]string
+// decode parses line as a single logfmt record. The upstream decoder's
+// ScanRecord advances by whole lines, so line must not hold more than one.
+// If opts.lenient is set, a malformed pair is dropped and decoding resumes
+// at the next whitespace instead of failing the whole record. The number of
+// dropped pairs is returned alongside the pairs that could be decoded.
+//
+// A bare key, such as a in "a b=1", gets noValue as its value.
+func decode(line string, opts decodeOptions) (pairs [][2]string, skipped int, err error) {
+	for {
+		// The decoder scans its input with a bufio.Scanner of its own, which
+		// has to be large enough to hold the whole line.
+		decoder := logfmt.NewDecoderSize(strings.NewReader(line), len(line)+1)
+		bare, quoted := scanPairs(line)
 
 		for decoder.ScanRecord() {
-			for decoder.ScanKeyval() {
+			for i := 0; decoder.ScanKeyval(); i++ {
 				key := string(decoder.Key())
 				val := string(decoder.Value())
+				// Keys are already validated by the decoder.
+				if !utf8.ValidString(val) {
+					if opts.validateUTF8 {
+						if !opts.lenient {
+							return pairs, skipped, fmt.Errorf("invalid UTF-8 in value of key %q", key)
+						}
+						skipped++
+						continue
+					}
+					// The encoders would write U+FFFD in place of every
+					// invalid byte, so transforms should see it too.
+					val = replaceInvalidUTF8(val)
+				}
+				if opts.maxPairs > 0 && len(pairs) == opts.maxPairs {
+					return pairs, skipped, fmt.Errorf("record has more than %d pairs", opts.maxPairs)
+				}
+				if i < len(bare) && bare[i] {
+					val = noValue
+				}
+				if opts.quoted != nil && i < len(quoted) && quoted[i] {
+					opts.quoted[[2]string{key, val}] = true
+				}
 				pairs = append(pairs, [2]string{key, val})
 			}
-			if err := decoder.Err(); err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
-				os.Exit(1)
+			if err = decoder.Err(); err != nil {
+				break
+			}
+		}
+
+		var syntaxErr *logfmt.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			return pairs, skipped, err
+		}
+		if !opts.lenient {
+			explainSyntaxError(line, syntaxErr)
+			return pairs, skipped, err
+		}
+		skipped++
+
+		// Pos is the 1-based column at which decoding failed.
+		line = line[min(syntaxErr.Pos-1, len(line)):]
+		i := strings.IndexFunc(line, func(r rune) bool { return r <= ' ' })
+		if i < 0 {
+			return pairs, skipped, nil
+		}
+		line = line[i:]
+	}
+}
+
+// noValue is the value of a bare key, which has no "=" and therefore no
+// value at all, unlike "a=". It is not valid UTF-8, while decode only
+// returns valid values, so it cannot be mistaken for one. Only the logfmt
+// encoders write it as a bare key; everything else sees an empty value.
+const noValue = "\xff"
+
+// scanPairs reports, for every pair of line in order, whether it is a bare
+// key and whether its value is quoted. The upstream decoder returns an
+// empty value for both "a" and "a=", and the same value for a=x and a="x",
+// so the line is scanned again like the decoder does. The result is only
+// meaningful up to the first malformed pair.
+func scanPairs(line string) (bare, quoted []bool) {
+	for i := 0; i < len(line); {
+		if line[i] <= ' ' {
+			i++
+			continue
+		}
+		for i < len(line) && line[i] > ' ' && line[i] != '=' {
+			i++
+		}
+		if i == len(line) || line[i] != '=' {
+			bare = append(bare, true)
+			quoted = append(quoted, false)
+			continue
+		}
+		bare = append(bare, false)
+		quoted = append(quoted, i+1 < len(line) && line[i+1] == '"')
+
+		// The value ends at the first whitespace outside quotes.
+		quoted, escaped := false, false
+		for i++; i < len(line) && (quoted || line[i] > ' '); i++ {
+			switch c := line[i]; {
+			case escaped:
+				escaped = false
+			case quoted && c == '\\':
+				escaped = true
+			case c == '"':
+				quoted = !quoted
 			}
 		}
+	}
+	return bare, quoted
+}
 
-		var buf bytes.Buffer
-		encoder := logfmt.NewEncoder(&buf)
-		for _, kv := range pairs {
-			_ = encoder.EncodeKeyval(kv[0], kv[1])
+// explainSyntaxError makes a syntax error the upstream decoder reported for
+// line more useful to a reader. An unterminated quoted value is reported at
+// its opening quote rather than at the end of the line, and the message
+// names the key of the offending pair where there is one.
+func explainSyntaxError(line string, err *logfmt.SyntaxError) {
+	start, end, open := 0, min(err.Pos-1, len(line)), -1
+	quoted, escaped := false, false
+	for i := 0; i < end; i++ {
+		switch c := line[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+			open = i
+		case !quoted && c <= ' ':
+			start = i + 1
 		}
-		_ = encoder.EndRecord()
+	}
+	if quoted {
+		end = open
+		err.Pos = open + 1
+	}
+
+	// Keys cannot contain '=', so the first one ends the key.
+	if key, _, ok := strings.Cut(line[start:end], "="); ok && key != "" {
+		err.Msg += fmt.Sprintf(" for key %q", key)
+	}
+}
+
+// crlfWriter replaces every "\n" written to it with "\r\n". Encoded values
+// never contain a raw newline, so only line terminators are affected. The
+// exception is a quoted CSV field, whose newlines are converted just like
+// encoding/csv does with UseCRLF.
+type crlfWriter struct {
+	w io.Writer
+}
 
-		fmt.Print(buf.String())
+func (c crlfWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m, err := c.w.Write(p)
+			return n + m, err
+		}
+		if _, err := c.w.Write(p[:i]); err != nil {
+			return n, err
+		}
+		if _, err := c.w.Write([]byte("\r\n")); err != nil {
+			return n + i, err
+		}
+		n += i + 1
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// replaceInvalidUTF8 replaces every byte of s that is not part of a valid
+// UTF-8 sequence with U+FFFD.
+func replaceInvalidUTF8(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// validKey reports whether the encoders write key as is. A key must not be
+// empty or hold spaces, "=", quotes, control characters or invalid UTF-8.
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsFunc(key, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError
+	})
+}
+
+// encodeLogfmt writes pairs to encoder as a single logfmt record. It fails
+// on the first key the encoder rejects, such as one that is left empty by
+// -key-replace. The encoder cannot write bare keys, see encodeStyled.
+func encodeLogfmt(encoder *logfmt.Encoder, pairs [][2]string) error {
+	for _, kv := range pairs {
+		if err := encoder.EncodeKeyval(kv[0], kv[1]); err != nil {
+			if errors.Is(err, logfmt.ErrInvalidKey) {
+				err = fmt.Errorf("%w %q", err, kv[0])
+			}
+			return err
+		}
+	}
+	return encoder.EndRecord()
+}
+
+// encodeStyled is like encodeLogfmt, but writes bare keys and can wrap
+// every key and value in escape codes and quote values that do not need it.
+func encodeStyled(w io.Writer, pairs [][2]string, colored, alwaysQuote bool) error {
+	var buf bytes.Buffer
+	for _, kv := range pairs {
+		key, value, err := encodePair(kv[0], kv[1], alwaysQuote)
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		if colored {
+			key, value = colorize(key, value, isSevere(kv[0], kv[1]))
+		}
+		buf.WriteString(key)
+		if kv[1] != noValue {
+			buf.WriteString("=" + value)
+		}
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodePair returns the logfmt spelling of key and value. The encoder never
+// lets "=" into a key, so the pair is split at the first one. If alwaysQuote
+// is set, values the encoder leaves bare are quoted as well. The value of a
+// bare key is empty. Keys are rejected like by encodeLogfmt.
+func encodePair(key, value string, alwaysQuote bool) (string, string, error) {
+	if value == noValue {
+		k, _, err := encodePair(key, "", false)
+		return k, "", err
+	}
+	b, err := logfmt.MarshalKeyvals(key, value)
+	if err != nil {
+		return "", "", fmt.Errorf("%w %q", err, key)
+	}
+	k, v, _ := strings.Cut(string(b), "=")
+	if alwaysQuote && !strings.HasPrefix(v, `"`) {
+		// A bare value has no quotes, whitespace or control characters, so
+		// backslashes are the only thing left to escape.
+		v = `"` + strings.ReplaceAll(v, `\`, `\\`) + `"`
 	}
+	return k, v, nil
 }
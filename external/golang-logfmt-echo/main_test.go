@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+func echo(t *testing.T, args []string, input string) (string, string, int) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(input), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func encode(t *testing.T, pairs [][2]string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encoder := logfmt.NewEncoder(&buf)
+	for _, kv := range pairs {
+		if err := encoder.EncodeKeyval(kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestKeyOrder(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "c=3 a=1 b=2 a=0\n"},
+		{[]string{"-sort"}, "a=1 a=0 b=2 c=3\n"},
+		{[]string{"-reverse"}, "a=0 b=2 a=1 c=3\n"},
+		{[]string{"-sort", "-reverse"}, "c=3 b=2 a=0 a=1\n"},
+		{[]string{"-where", "b=2", "-exclude", "x", "-rename", "x=y", "-max-value-len", "5"}, "c=3 a=1 b=2 a=0\n"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, "c=3 a=1 b=2 a=0\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want)
+		}
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	input := "msg=" + strings.Repeat("a", 200<<10) + "\n"
+	stdout, stderr, code := echo(t, nil, input)
+
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if stdout != input {
+		t.Errorf("got %d bytes, want %d", len(stdout), len(input))
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	input := "a=1\r\nmsg=" + strings.Repeat("a", 2048) + "\n"
+	stdout, stderr, code := echo(t, []string{"-max-line", "1024"}, input)
+
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if stdout != "a=1\n" {
+		t.Errorf("got %q, want %q", stdout, "a=1\n")
+	}
+	if want := "error: line at byte offset 5 exceeds 1024 bytes\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+func TestMaxLine(t *testing.T) {
+	for _, tt := range []struct {
+		max, input string
+		code       int
+	}{
+		// The terminator does not count towards the limit.
+		{"3", "a=1\n", 0},
+		{"3", "a=1\r\n", 0},
+		{"3", "a=1\r", 0},
+		{"3", "a=1", 0},
+		{"3", "a=12\n", 1},
+		{"3", "a=12", 1},
+		{"0", "a=1\n", 2},
+		{"-1", "a=1\n", 2},
+	} {
+		stdout, stderr, code := echo(t, []string{"-max-line", tt.max}, tt.input)
+		if code != tt.code {
+			t.Errorf("-max-line %s, %q: exit code %d, want %d: %s", tt.max, tt.input, code, tt.code, stderr)
+		}
+		if code == 0 && stdout != "a=1\n" {
+			t.Errorf("-max-line %s, %q: got %q", tt.max, tt.input, stdout)
+		}
+	}
+}
+
+func TestBufSize(t *testing.T) {
+	input := "a=1\nmsg=" + strings.Repeat("a", 2048) + "\n"
+
+	for _, tt := range []struct {
+		args []string
+		code int
+	}{
+		// The buffer grows past its initial size, but never past -max-line.
+		{[]string{"-buf-size", "16"}, 0},
+		{[]string{"-buf-size", "0"}, 0},
+		{[]string{"-buf-size", "16", "-max-line", "1024"}, 1},
+		{[]string{"-buf-size", "4096", "-max-line", "1024"}, 1},
+		{[]string{"-buf-size", "-1"}, 2},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != tt.code {
+			t.Fatalf("%v: exit code %d, want %d: %s", tt.args, code, tt.code, stderr)
+		}
+		if code == 0 && stdout != input {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, input)
+		}
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	for _, input := range []string{
+		"a=1\r\nb=\"x y\"\r\n",
+		"a=1\rb=\"x y\"\r",
+		"a=1\nb=\"x y\"",
+		"a=1\r\nb=\"x y\"\r",
+	} {
+		for _, r := range []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input))} {
+			var stdout, stderr bytes.Buffer
+			if code := run(context.Background(), []string{"-to", "json"}, r, &stdout, &stderr); code != 0 {
+				t.Fatalf("%q: exit code %d: %s", input, code, stderr.String())
+			}
+			if want := "{\"a\":\"1\"}\n{\"b\":\"x y\"}\n"; stdout.String() != want {
+				t.Errorf("%q: got %q, want %q", input, stdout.String(), want)
+			}
+		}
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	input := "a=1 msg=\"two\\nlines\\r\"\nb=2\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "a=1 msg=\"two\\nlines\\r\"\nb=2\n"},
+		{[]string{"-crlf"}, "a=1 msg=\"two\\nlines\\r\"\r\nb=2\r\n"},
+		{[]string{"-crlf", "-to", "json"}, "{\"a\":\"1\",\"msg\":\"two\\nlines\\r\"}\r\n{\"b\":\"2\"}\r\n"},
+		{[]string{"-crlf", "-pretty"}, "---\r\n  a   = 1\r\n  msg = \"two\\nlines\\r\"\r\n---\r\n  b = 2\r\n"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want)
+		}
+	}
+
+	// The output reads back as the same records.
+	stdout, _, _ := echo(t, []string{"-crlf"}, input)
+	if again, _, _ := echo(t, nil, stdout); again != input {
+		t.Errorf("got %q, want %q", again, input)
+	}
+}
+
+func TestBlankLines(t *testing.T) {
+	stdout, stderr, code := echo(t, nil, "a=1\r\n\r\n\n\rb=2\n")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1\n\n\n\nb=2\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	stdout, stderr, code := echo(t, nil, "a=1 b=x\"y c=3\nd=4\n")
+
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if stdout != "" {
+		t.Errorf("got %q, want no output", stdout)
+	}
+	if !strings.HasPrefix(stderr, "error: logfmt syntax error") {
+		t.Errorf("got %q, want a syntax error", stderr)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, tt := range []struct {
+		args  []string
+		input string
+		pos   int
+		msg   string
+	}{
+		{nil, `a=1 msg="oops`, 9, `unterminated quoted value for key "msg"`},
+		{nil, `a=1 msg="oops \" c=3`, 9, `unterminated quoted value for key "msg"`},
+		{nil, `a=1 b=x"y c=3`, 8, `unexpected '"' for key "b"`},
+		{nil, `a=1 "b"=2`, 5, `unexpected '"'`},
+		{[]string{"-records-per-line", "many"}, "a=1\x1eb=2 c=\"x", 11, `unterminated quoted value for key "c"`},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			_, stderr, code := echo(t, tt.args, "z=0\n"+tt.input+"\n")
+			if code != 1 {
+				t.Fatalf("exit code %d, want 1", code)
+			}
+			want := fmt.Sprintf("error: logfmt syntax error at pos %d on line 2: %s\n", tt.pos, tt.msg)
+			if stderr != want {
+				t.Errorf("got %q, want %q", stderr, want)
+			}
+		})
+	}
+}
+
+func TestDecodeSyntaxError(t *testing.T) {
+	_, _, err := decode(`msg="oops`, decodeOptions{})
+
+	var syntaxErr *logfmt.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("got %v, want a *logfmt.SyntaxError", err)
+	}
+	if syntaxErr.Pos != 5 || syntaxErr.Msg != `unterminated quoted value for key "msg"` {
+		t.Errorf("got pos %d and message %q", syntaxErr.Pos, syntaxErr.Msg)
+	}
+}
+
+func TestSkipInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		input, want string
+		skipped     int
+	}{
+		{"a=1 b=x\"y c=3", "a=1 c=3", 1},
+		{"a=1 =x c=3", "a=1 c=3", 1},
+		{"a=1 b=\"x\\q\" c=3", "a=1 c=3", 1},
+		{"a=1 b=\"unterminated c=3", "a=1", 1},
+		{"a=1 b=2", "a=1 b=2", 0},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			pairs, skipped, err := decode(tt.input, decodeOptions{lenient: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := encode(t, pairs); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if skipped != tt.skipped {
+				t.Errorf("skipped %d pairs, want %d", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestSkipInvalidReport(t *testing.T) {
+	stdout, stderr, code := echo(t, []string{"-skip-invalid"}, "a=1 b=x\"y c=3\nd=4\n")
+
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1 c=3\nd=4\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if want := "skipped 1 malformed pairs\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+func TestControlCharacterRoundTrip(t *testing.T) {
+	alphabet := []rune("ab =\"\\\n\r\t\x00\x01\x1b\x1f\x7fü🌍")
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		value := make([]rune, rng.Intn(16))
+		for j := range value {
+			value[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+
+		var buf bytes.Buffer
+		if err := encodeLogfmt(logfmt.NewEncoder(&buf), [][2]string{{"v", string(value)}}); err != nil {
+			t.Fatal(err)
+		}
+		line, ok := strings.CutSuffix(buf.String(), "\n")
+		if !ok || strings.ContainsAny(line, "\n\r") {
+			t.Fatalf("%q: encoded as %q, want a single line", string(value), buf.String())
+		}
+
+		pairs, _, err := decode(line, decodeOptions{})
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if len(pairs) != 1 || pairs[0][1] != string(value) {
+			t.Fatalf("%q: decoded as %q, want %q", line, pairs, string(value))
+		}
+	}
+}
+
+func TestValidateUTF8(t *testing.T) {
+	input := "a=1 msg=\"bad \xff byte\" b=2\nc=\xff\n"
+
+	stdout, stderr, code := echo(t, nil, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1 msg=\"bad \\ufffd byte\" b=2\nc=\"\\ufffd\"\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	stdout, stderr, code = echo(t, []string{"-validate-utf8"}, input)
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if stdout != "" {
+		t.Errorf("got %q, want no output", stdout)
+	}
+	if want := "error: line 1: invalid UTF-8 in value of key \"msg\"\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+
+	stdout, stderr, code = echo(t, []string{"-validate-utf8", "-skip-invalid"}, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "a=1 b=2\n\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if want := "skipped 2 malformed pairs\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+func TestAlwaysQuote(t *testing.T) {
+	input := `a=hello b="hello world" c= d=x\y e="say \"hi\"" f="null" g=🌍` + "\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, `a=hello b="hello world" c= d=x\y e="say \"hi\"" f="null" g=🌍`},
+		{[]string{"-always-quote"}, `a="hello" b="hello world" c="" d="x\\y" e="say \"hi\"" f="null" g="🌍"`},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want+"\n")
+		}
+
+		// Both spellings decode to the same pairs.
+		if again, _, _ := echo(t, nil, stdout); again != input {
+			t.Errorf("%v: re-encoded as %q", tt.args, again)
+		}
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"a=",
+		"a b=1 c",
+		"a=1 a=2",
+		`msg="hello world" at=INFO`,
+		`a="" b="\"quoted\"" c="back\\slash"`,
+		`a="\n\t\u0001\u00ff"`,
+		`a=null b="null"`,
+		"k=\xff",
+		"🌍=🌍",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		// The scanner never hands the decoder a line terminator.
+		if strings.ContainsAny(line, "\r\n") {
+			t.Skip()
+		}
+		first, _, err := decode(line, decodeOptions{})
+		if err != nil {
+			t.Skip()
+		}
+
+		// Unlike encodeLogfmt, encodeStyled also writes bare keys.
+		var buf bytes.Buffer
+		if err := encodeStyled(&buf, first, false, false); err != nil {
+			t.Fatal(err)
+		}
+		second, _, err := decode(strings.TrimSuffix(buf.String(), "\n"), decodeOptions{})
+		if err != nil {
+			t.Fatalf("%q re-encoded as %q: %v", line, buf.String(), err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("%q decoded as %q, re-encoded as %q and decoded as %q", line, first, buf.String(), second)
+		}
+	})
+}
+
+func TestBareKeys(t *testing.T) {
+	pairs, _, err := decode("a b=1 c", decodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]string{{"a", noValue}, {"b", "1"}, {"c", noValue}}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("got %q, want %q", pairs, want)
+	}
+
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{nil, "a b=1 c", "a b=1 c"},
+		{nil, `a= b c="x y" d=`, `a= b c="x y" d=`},
+		{nil, `q="a b" flag z="\" c"`, `q="a b" flag z="\" c"`},
+		{nil, "a k=\xff", `a k="\ufffd"`},
+		{[]string{"-sort", "-rename", "c=d"}, "c b=1 a", "a b=1 d"},
+		{[]string{"-where", "a="}, "a b=1 c", "a b=1 c"},
+		{[]string{"-skip-invalid"}, `a "x"=1 b c=2 d`, "a b c=2 d"},
+		{[]string{"-always-quote"}, "a b=1 c", `a b="1" c`},
+		{[]string{"-to", "json"}, "a b=1 c", `{"a":"","b":"1","c":""}`},
+		{[]string{"-to", "csv", "-columns", "a,b"}, "a b=1 c", "a,b\n,1"},
+		{[]string{"-pretty"}, "a bb=1 c", "---\n  a\n  bb = 1\n  c"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want+"\n")
+		}
+	}
+}
+
+func TestMaxPairs(t *testing.T) {
+	var record strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&record, "k%d=%d ", i, i)
+	}
+
+	pairs, _, err := decode(record.String(), decodeOptions{maxPairs: 100})
+	if err == nil || err.Error() != "record has more than 100 pairs" {
+		t.Errorf("got error %v, want the limit to be exceeded", err)
+	}
+	if len(pairs) != 100 {
+		t.Errorf("decoded %d pairs before stopping, want 100", len(pairs))
+	}
+
+	if _, _, err := decode("a=1 b=2 c=3", decodeOptions{maxPairs: 3}); err != nil {
+		t.Errorf("got %v for a record at the limit", err)
+	}
+
+	for _, args := range [][]string{{"-max-pairs", "100"}, {"-max-pairs", "100", "-skip-invalid"}} {
+		stdout, stderr, code := echo(t, args, "a=1\n"+record.String()+"\n")
+		if code != 1 {
+			t.Fatalf("%v: exit code %d, want 1", args, code)
+		}
+		if stdout != "a=1\n" {
+			t.Errorf("%v: got %q, want %q", args, stdout, "a=1\n")
+		}
+		if want := "error: line 2: record has more than 100 pairs\n"; stderr != want {
+			t.Errorf("%v: got %q, want %q", args, stderr, want)
+		}
+	}
+
+	if _, _, code := echo(t, []string{"-max-pairs", "-1"}, "a=1\n"); code != 2 {
+		t.Errorf("-max-pairs -1: exit code %d, want 2", code)
+	}
+}
+
+func benchmarkEcho(b *testing.B, args []string) {
+	input := strings.Repeat("ts=2025-07-30T12:00:00Z level=info msg=\"request handled\" status=200 method=GET path=/\n", 1000)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		run(context.Background(), args, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{})
+	}
+}
+
+func BenchmarkUnsorted(b *testing.B) { benchmarkEcho(b, nil) }
+
+func BenchmarkSorted(b *testing.B) { benchmarkEcho(b, []string{"-sort"}) }
+
+var benchmarkPairs = [][2]string{
+	{"ts", "2025-07-30T12:00:00Z"},
+	{"level", "info"},
+	{"msg", "request handled"},
+	{"status", "200"},
+}
+
+func BenchmarkEncodePerRecord(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		_ = encodeLogfmt(logfmt.NewEncoder(&buf), benchmarkPairs)
+		io.Discard.Write(buf.Bytes())
+	}
+}
+
+func BenchmarkEncodeShared(b *testing.B) {
+	encoder := logfmt.NewEncoder(bufio.NewWriter(io.Discard))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = encodeLogfmt(encoder, benchmarkPairs)
+	}
+}
+
+func TestRecordsPerLine(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{nil, "a=1\x1eb=2\n", "a=1 b=2\n"},
+		{[]string{"-records-per-line=many"}, "a=1\x1eb=2\n", "a=1\nb=2\n"},
+		{[]string{"-records-per-line=many"}, "a=1 \x1e b=2\nc=3\n", "a=1\nb=2\nc=3\n"},
+		{[]string{"-records-per-line=many", "-record-sep", " | "}, "a=1 | b=\"x | y\" | c=\"\\\" | \"\n", "a=1\nb=\"x | y\"\nc=\"\\\" | \"\n"},
+		{[]string{"-records-per-line=many", "-record-sep", ";;"}, "a=1;;;;b=2\n", "a=1\n\nb=2\n"},
+		{[]string{"-records-per-line=many", "-record-sep", ";;", "-drop-empty"}, "a=1;;;;b=2\n", "a=1\nb=2\n"},
+		{[]string{"-records-per-line", "1"}, "a=1\x1eb=2\n", "a=1 b=2\n"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v %q: got %q, want %q", tt.args, tt.input, stdout, tt.want)
+		}
+	}
+}
+
+func TestRecordsPerLineInvalid(t *testing.T) {
+	for _, args := range [][]string{{"-records-per-line=2"}, {"-record-sep="}} {
+		if _, _, code := echo(t, args, ""); code != 2 {
+			t.Errorf("%v: exit code %d, want 2", args, code)
+		}
+	}
+}
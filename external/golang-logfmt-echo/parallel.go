@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+)
+
+// A job is an input line waiting to be processed.
+type job struct {
+	lineNum int
+	line    string
+}
+
+// A result is the output of a processed line.
+type result struct {
+	lineNum int
+	out     []byte
+	errOut  []byte
+	err     error
+}
+
+// processParallel processes the lines read by scanner with n goroutines and
+// writes the results to o in input order. Each goroutine writes to a buffer
+// of its own, and finished lines are held back until all lines before them
+// have been written.
+//
+// It returns the first error returned by process or o, once all goroutines
+// have exited. Otherwise, the caller must check scanner.Err.
+func (tc *transcoder) processParallel(o *output, scanner *bufio.Scanner, n int) error {
+	jobs := make(chan job, n)
+	// The producer takes a token for every line and the collector returns
+	// it once the line has been written, which bounds the lines held back.
+	// results can hold all of them, so workers never block on it.
+	tokens := make(chan struct{}, 4*n)
+	results := make(chan result, cap(tokens))
+	done := make(chan struct{})
+
+	go func() {
+		defer close(jobs)
+		lines := 0
+		for scanner.Scan() {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			// The select above may take a free token even once done is closed.
+			select {
+			case <-done:
+				return
+			default:
+			}
+			lines++
+			jobs <- job{lineNum: lines, line: scanner.Text()}
+		}
+	}()
+
+	outputs := make([]*output, n)
+	var wg sync.WaitGroup
+	for i := range outputs {
+		var buf, errBuf bytes.Buffer
+		outputs[i] = tc.newOutput(&buf, &errBuf)
+		wg.Add(1)
+		go func(w *output) {
+			defer wg.Done()
+			for j := range jobs {
+				buf.Reset()
+				errBuf.Reset()
+				err := tc.process(w, j.lineNum, j.line)
+				results <- result{
+					lineNum: j.lineNum,
+					out:     bytes.Clone(buf.Bytes()),
+					errOut:  bytes.Clone(errBuf.Bytes()),
+					err:     err,
+				}
+			}
+		}(outputs[i])
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// stop makes the producer return and waits for the workers to finish
+	// the lines they hold, so that none of them uses scanner or calls
+	// transform once processParallel has returned.
+	stop := func(err error) error {
+		close(done)
+		if tc.cancel != nil {
+			tc.cancel()
+		}
+		for range results {
+		}
+		return err
+	}
+
+	pending := make(map[int]result)
+	next := 1
+	for r := range results {
+		pending[r.lineNum] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-tokens
+
+			o.errw.Write(r.errOut)
+			if _, err := o.w.Write(r.out); err != nil {
+				return stop(err)
+			}
+			if r.err != nil {
+				return stop(r.err)
+			}
+		}
+		if tc.flush != nil {
+			if err := tc.flush(); err != nil {
+				return stop(err)
+			}
+		}
+	}
+
+	for _, w := range outputs {
+		o.merge(w)
+	}
+	return nil
+}
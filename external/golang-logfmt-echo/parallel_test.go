@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelOrder(t *testing.T) {
+	// -add-ts reads the clock while transforming a record. Making the first
+	// lines read it slowest forces later lines to finish before them.
+	var calls atomic.Int64
+	defer func(prev func() time.Time) { now = prev }(now)
+	now = func() time.Time {
+		if n := calls.Add(1); n <= 4 {
+			time.Sleep(time.Duration(5-n) * 10 * time.Millisecond)
+		}
+		return time.Date(2025, 7, 30, 12, 0, 0, 0, time.UTC)
+	}
+
+	var input, want strings.Builder
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&input, "i=%d\n", i)
+		fmt.Fprintf(&want, "ts=2025-07-30T12:00:00Z i=%d\n", i)
+	}
+
+	stdout, stderr, code := echo(t, []string{"-parallel", "4", "-add-ts"}, input.String())
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if stdout != want.String() {
+		t.Errorf("got %q, want %q", stdout, want.String())
+	}
+}
+
+func TestParallelMatchesSequential(t *testing.T) {
+	input := strings.Repeat("a=1 b=x level=info\nnot-json b=2 a=3 b=4\n\nc= a=5 bad=\"x\n", 50)
+
+	for _, args := range [][]string{
+		{"-skip-invalid"},
+		{"-skip-invalid", "-sort", "-duplicates", "last"},
+		{"-skip-invalid", "-to", "json"},
+		{"-skip-invalid", "-to", "csv", "-columns", "a,b", "-csv-extra"},
+		{"-skip-invalid", "-stats", "-stats-key", "a"},
+		{"-from", "json"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			wantOut, wantErr, wantCode := echo(t, args, input)
+			gotOut, gotErr, gotCode := echo(t, append([]string{"-parallel", "3"}, args...), input)
+			if gotCode != wantCode {
+				t.Errorf("exit code %d, want %d", gotCode, wantCode)
+			}
+			if gotOut != wantOut {
+				t.Errorf("got %q, want %q", gotOut, wantOut)
+			}
+			if gotErr != wantErr {
+				t.Errorf("got error output %q, want %q", gotErr, wantErr)
+			}
+		})
+	}
+}
+
+func TestParallelError(t *testing.T) {
+	var input, want strings.Builder
+	for i := 1; i <= 100; i++ {
+		if i == 60 {
+			input.WriteString("bad=\"x\n")
+			continue
+		}
+		fmt.Fprintf(&input, "i=%d\n", i)
+		if i < 60 {
+			fmt.Fprintf(&want, "i=%d\n", i)
+		}
+	}
+
+	stdout, stderr, code := echo(t, []string{"-parallel", "4"}, input.String())
+	if code != 1 {
+		t.Fatalf("exit code %d, want 1", code)
+	}
+	if stdout != want.String() {
+		t.Errorf("got %q, want %q", stdout, want.String())
+	}
+	if want := "error: logfmt syntax error at pos 5 on line 60: unterminated quoted value for key \"bad\"\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+func TestParallelErrorWaits(t *testing.T) {
+	// The first line fails while the other workers are still busy with the
+	// lines after it.
+	input := "bad=\"x\n" + strings.Repeat("a=1\n", 100)
+	var calls atomic.Int64
+	tc := newTranscoder(strings.NewReader(input), io.Discard, func(pairs [][2]string) [][2]string {
+		time.Sleep(time.Millisecond)
+		calls.Add(1)
+		return pairs
+	})
+	tc.parallel = 4
+
+	if err := tc.Run(); err == nil {
+		t.Fatal("no error")
+	}
+	n := calls.Load()
+	time.Sleep(20 * time.Millisecond)
+	if got := calls.Load(); got != n {
+		t.Errorf("transform called %d more times after Run returned", got-n)
+	}
+}
+
+func TestParallelFollowError(t *testing.T) {
+	defer func(prev time.Duration) { pollInterval = prev }(pollInterval)
+	pollInterval = 5 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("a=1\nbad=\"x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed line must end -follow instead of waiting for more input.
+	var stdout, stderr syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- run(context.Background(), []string{"-follow", "-parallel", "2", path}, strings.NewReader(""), &stdout, &stderr)
+	}()
+	select {
+	case code := <-done:
+		if code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return")
+	}
+	if want := "a=1\n"; stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout.String(), want)
+	}
+}
+
+func BenchmarkParallel(b *testing.B) {
+	input := strings.Repeat("ts=2025-07-30T12:00:00Z level=info msg=\"request handled\" status=200 method=GET path=/\n", 10000)
+
+	for n := 1; n <= runtime.GOMAXPROCS(0); n *= 2 {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			args := []string{"-parallel", fmt.Sprint(n), "-to", "json"}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				run(context.Background(), args, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{})
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// encodePretty writes pairs to w below a "---" separator, one pair per
+// indented line, with the "=" signs of the record aligned. Keys and values
+// are spelled exactly as the logfmt encoder would emit them, so a value
+// that needs quoting is still quoted. So is a pair in quoted, which holds
+// the pairs quoted in the input; a pair changed by a transform loses its
+// quotes. A bare key is written without "=".
+func encodePretty(w io.Writer, pairs [][2]string, quoted map[[2]string]bool, colored, alwaysQuote bool) error {
+	keys := make([]string, 0, len(pairs))
+	values := make([]string, 0, len(pairs))
+	bare := make([]bool, 0, len(pairs))
+	severe := make([]bool, 0, len(pairs))
+	width := 0
+	for _, kv := range pairs {
+		key, value, err := encodePair(kv[0], kv[1], alwaysQuote || quoted[kv])
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+		bare = append(bare, kv[1] == noValue)
+		severe = append(severe, isSevere(kv[0], kv[1]))
+		width = max(width, utf8.RuneCountInString(key))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	for i, key := range keys {
+		padding := strings.Repeat(" ", width-utf8.RuneCountInString(key))
+		value := values[i]
+		if colored {
+			key, value = colorize(key, value, severe[i])
+		}
+		line := fmt.Sprintf("  %s%s = %s", key, padding, value)
+		if bare[i] {
+			line = "  " + key
+		}
+		buf.WriteString(strings.TrimRight(line, " "))
+		buf.WriteByte('\n')
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestPretty(t *testing.T) {
+	input := "at=INFO msg=\"hello world\" duration_ms=12\nempty=\na=\"hello\" b=x c=\"\"\n"
+
+	stdout, stderr, code := echo(t, []string{"-pretty"}, input)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	golden := filepath.Join("testdata", "pretty.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(stdout), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
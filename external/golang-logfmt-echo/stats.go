@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+// stats aggregates the records seen by -stats.
+type stats struct {
+	records int
+	keyvals int
+	keys    map[string]int
+	// histogramKey is the key whose values are counted in histogram, if
+	// any.
+	histogramKey string
+	histogram    map[string]int
+}
+
+func newStats(histogramKey string) *stats {
+	return &stats{
+		keys:         make(map[string]int),
+		histogramKey: histogramKey,
+		histogram:    make(map[string]int),
+	}
+}
+
+func (s *stats) add(pairs [][2]string) {
+	s.records++
+	s.keyvals += len(pairs)
+	for _, kv := range pairs {
+		s.keys[kv[0]]++
+		if s.histogramKey != "" && kv[0] == s.histogramKey {
+			s.histogram[kv[1]]++
+		}
+	}
+}
+
+// write encodes the summary as logfmt: a totals record, one record per
+// distinct key and, if histogramKey is set, one record per distinct value
+// of that key. Keys and values are listed in lexicographic order.
+func (s *stats) write(encoder *logfmt.Encoder) error {
+	records := [][][2]string{{
+		{"records", strconv.Itoa(s.records)},
+		{"keyvals", strconv.Itoa(s.keyvals)},
+		{"keys", strconv.Itoa(len(s.keys))},
+	}}
+	for _, key := range sortedKeys(s.keys) {
+		records = append(records, [][2]string{
+			{"key", key},
+			{"count", strconv.Itoa(s.keys[key])},
+		})
+	}
+	for _, value := range sortedKeys(s.histogram) {
+		records = append(records, [][2]string{
+			{"key", s.histogramKey},
+			{"value", value},
+			{"count", strconv.Itoa(s.histogram[value])},
+		})
+	}
+
+	for _, pairs := range records {
+		if err := encodeLogfmt(encoder, pairs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// merge adds the records counted by other to s. Both must count the same
+// histogram key.
+func (s *stats) merge(other *stats) {
+	s.records += other.records
+	s.keyvals += other.keyvals
+	for key, n := range other.keys {
+		s.keys[key] += n
+	}
+	for value, n := range other.histogram {
+		s.histogram[value] += n
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	input := "level=info msg=started\nlevel=error msg=\"disk full\" host=a\nlevel=info msg=done\n\nmsg=bare\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-stats"},
+			"records=5 keyvals=8 keys=3\n" +
+				"key=host count=1\n" +
+				"key=level count=3\n" +
+				"key=msg count=4\n",
+		},
+		{
+			[]string{"-stats", "-stats-key", "level"},
+			"records=5 keyvals=8 keys=3\n" +
+				"key=host count=1\n" +
+				"key=level count=3\n" +
+				"key=msg count=4\n" +
+				"key=level value=error count=1\n" +
+				"key=level value=info count=2\n",
+		},
+		{
+			[]string{"-stats", "-stats-key", "level", "-where", "level=error"},
+			"records=1 keyvals=3 keys=3\n" +
+				"key=host count=1\n" +
+				"key=level count=1\n" +
+				"key=msg count=1\n" +
+				"key=level value=error count=1\n",
+		},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got:\n%s\nwant:\n%s", tt.args, stdout, tt.want)
+		}
+	}
+}
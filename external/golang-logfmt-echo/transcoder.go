@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/go-logfmt/logfmt"
+)
+
+// A transcoder reads records from r, passes each one through transform and
+// writes the result to w. The fields set by newTranscoder may be changed
+// before calling Run.
+//
+// transform may modify the pairs in place. A nil result drops the record,
+// while an empty one is written as an empty record. Bare keys have the value
+// noValue. With parallel > 1, transform is called from several goroutines at
+// once.
+type transcoder struct {
+	r         io.Reader
+	w         io.Writer
+	transform func([][2]string) [][2]string
+	// errw receives reports of input that is skipped rather than aborting
+	// Run.
+	errw io.Writer
+	// flush, if set, is called whenever all records read so far have been
+	// written to w.
+	flush func() error
+	// cancel, if set, is called when an error stops Run while lines are
+	// still being read in the background, so that a read waiting for
+	// more input, as with -follow, returns.
+	cancel func()
+
+	from        string
+	manyRecords bool
+	recordSep   string
+	decodeOpts  decodeOptions
+	strict      bool
+	// bufSize is the initial capacity of the line buffer, which grows as
+	// needed up to maxLine.
+	bufSize  int
+	maxLine  int
+	parallel int
+
+	encodeOpts encodeOptions
+}
+
+// encodeOptions controls how a transcoder writes records.
+type encodeOptions struct {
+	// to is the output format: logfmt, json or csv.
+	to string
+	// printStats counts records instead of writing them, and statsKey, if
+	// set, names the key whose values are counted.
+	printStats bool
+	statsKey   string
+	// columns and csvExtra lay out CSV output.
+	columns  []string
+	csvExtra bool
+	// inferTypes and nest apply to JSON output.
+	inferTypes bool
+	nest       bool
+	// pretty, colored and alwaysQuote apply to logfmt output.
+	pretty      bool
+	colored     bool
+	alwaysQuote bool
+}
+
+// newTranscoder returns a transcoder from logfmt to logfmt, processing one
+// line at a time.
+func newTranscoder(r io.Reader, w io.Writer, transform func([][2]string) [][2]string) *transcoder {
+	return &transcoder{
+		r:         r,
+		w:         w,
+		transform: transform,
+		errw:      io.Discard,
+		from:      "logfmt",
+		recordSep: "\x1e",
+		bufSize:   4 << 10,
+		maxLine:   16 << 20,
+		parallel:  1,
+		encodeOpts: encodeOptions{
+			to: "logfmt",
+		},
+	}
+}
+
+// Run transcodes r until EOF, and returns the first error that stops it.
+func (tc *transcoder) Run() error {
+	if tc.maxLine < 1 {
+		return fmt.Errorf("maximum line length %d is not positive", tc.maxLine)
+	}
+	if tc.bufSize < 0 {
+		return fmt.Errorf("initial buffer size %d is negative", tc.bufSize)
+	}
+
+	o := tc.newOutput(tc.w, tc.errw)
+	if o.table != nil {
+		if err := o.table.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	var offset int64
+	scanner := bufio.NewScanner(tc.r)
+	// maxLine does not include the line terminator, which takes up to two
+	// more bytes in the buffer.
+	scanner.Buffer(make([]byte, 0, min(tc.bufSize, tc.maxLine)), min(tc.maxLine, math.MaxInt-2)+2)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := scanLines(data, atEOF)
+		if len(token) > tc.maxLine {
+			return 0, nil, bufio.ErrTooLong
+		}
+		offset += int64(advance)
+		return advance, token, err
+	})
+
+	if tc.parallel > 1 {
+		if err := tc.processParallel(o, scanner, tc.parallel); err != nil {
+			return err
+		}
+	} else {
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			err := tc.process(o, lineNum, scanner.Text())
+			if err == nil && tc.flush != nil {
+				err = tc.flush()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line at byte offset %d exceeds %d bytes", offset, tc.maxLine)
+		}
+		return err
+	}
+
+	if o.skipped > 0 {
+		fmt.Fprintf(tc.errw, "skipped %d malformed pairs\n", o.skipped)
+	}
+	if o.summary != nil {
+		return o.summary.write(o.encoder)
+	}
+	return nil
+}
+
+// An output receives the records processed by a transcoder, and reports of
+// the input it rejected.
+type output struct {
+	w, errw io.Writer
+	// A single encoder is shared by all records, so encoding a record does
+	// not allocate.
+	encoder *logfmt.Encoder
+	table   *csvEncoder
+	// summary is non-nil with -stats.
+	summary *stats
+	skipped int
+}
+
+// newOutput returns an output writing records to w and reports to errw. A
+// CSV header is not written.
+func (tc *transcoder) newOutput(w, errw io.Writer) *output {
+	o := &output{w: w, errw: errw, encoder: logfmt.NewEncoder(w)}
+	switch {
+	case tc.encodeOpts.printStats:
+		o.summary = newStats(tc.encodeOpts.statsKey)
+	case tc.encodeOpts.to == "csv":
+		o.table = newCSVEncoder(w, tc.encodeOpts.columns, tc.encodeOpts.csvExtra)
+	}
+	return o
+}
+
+// merge adds the summary and skipped pairs of other to o.
+func (o *output) merge(other *output) {
+	o.skipped += other.skipped
+	if o.summary != nil {
+		o.summary.merge(other.summary)
+	}
+}
+
+// process decodes line, the lineNum-th line of the input, and writes its
+// records to o. Errors that should abort Run are returned, others are
+// reported to o.errw.
+func (tc *transcoder) process(o *output, lineNum int, line string) error {
+	records := []string{line}
+	if tc.manyRecords && tc.from == "logfmt" {
+		records = splitRecords(line, tc.recordSep)
+	}
+
+	next := 0
+	for _, record := range records {
+		recordOffset := next
+		next += len(record) + len(tc.recordSep)
+
+		var pairs [][2]string
+		var quoted map[[2]string]bool
+		var err error
+
+		switch tc.from {
+		case "json":
+			if pairs, err = decodeJSON(record); err != nil {
+				if tc.strict {
+					return fmt.Errorf("line %d: %w", lineNum, err)
+				}
+				fmt.Fprintf(o.errw, "error: line %d: %v\n", lineNum, err)
+				continue
+			}
+		default:
+			var n int
+			opts := tc.decodeOpts
+			if tc.encodeOpts.pretty {
+				quoted = make(map[[2]string]bool)
+				opts.quoted = quoted
+			}
+			pairs, n, err = decode(record, opts)
+			o.skipped += n
+			if err != nil {
+				// decode only sees the record, so a syntax error is
+				// relative to it rather than to the input.
+				var syntaxErr *logfmt.SyntaxError
+				if errors.As(err, &syntaxErr) {
+					syntaxErr.Line = lineNum
+					syntaxErr.Pos += recordOffset
+					return err
+				}
+				return fmt.Errorf("line %d: %w", lineNum, err)
+			}
+		}
+
+		if pairs == nil {
+			// Keep blank lines apart from dropped records.
+			pairs = [][2]string{}
+		}
+		if pairs = tc.transform(pairs); pairs == nil {
+			continue
+		}
+
+		bare := slices.ContainsFunc(pairs, func(kv [2]string) bool { return kv[1] == noValue })
+		if bare && (o.summary != nil || tc.encodeOpts.to != "logfmt") {
+			// Only logfmt has bare keys.
+			for i := range pairs {
+				if pairs[i][1] == noValue {
+					pairs[i][1] = ""
+				}
+			}
+		}
+
+		if o.summary != nil {
+			o.summary.add(pairs)
+			continue
+		}
+
+		switch {
+		case tc.encodeOpts.to == "json":
+			err = encodeJSON(o.w, pairs, tc.encodeOpts.inferTypes, tc.encodeOpts.nest)
+		case tc.encodeOpts.to == "csv":
+			err = o.table.encode(pairs)
+		case tc.encodeOpts.pretty:
+			err = encodePretty(o.w, pairs, quoted, tc.encodeOpts.colored, tc.encodeOpts.alwaysQuote)
+		case tc.encodeOpts.colored || tc.encodeOpts.alwaysQuote || bare:
+			err = encodeStyled(o.w, pairs, tc.encodeOpts.colored, tc.encodeOpts.alwaysQuote)
+		default:
+			err = encodeLogfmt(o.encoder, pairs)
+		}
+		if err != nil {
+			// Like a line that is not valid JSON, a record -nest cannot
+			// nest only stops Run with strict set.
+			var nestErr *nestError
+			if errors.As(err, &nestErr) && !tc.strict {
+				fmt.Fprintf(o.errw, "error: line %d: %v\n", lineNum, err)
+				continue
+			}
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return nil
+}
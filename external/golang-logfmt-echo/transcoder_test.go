@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func transcode(t *testing.T, input string, transform func([][2]string) [][2]string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := newTranscoder(strings.NewReader(input), &out, transform).Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestTranscoderDropKey(t *testing.T) {
+	got := transcode(t, "a=1 secret=x b=2\nsecret=y\n\nc=\"3 4\"\n", func(pairs [][2]string) [][2]string {
+		kept := pairs[:0]
+		for _, kv := range pairs {
+			if kv[0] != "secret" {
+				kept = append(kept, kv)
+			}
+		}
+		return kept
+	})
+	if want := "a=1 b=2\n\n\nc=\"3 4\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTranscoderAddKey(t *testing.T) {
+	got := transcode(t, "a=1\nb=2\n", func(pairs [][2]string) [][2]string {
+		return append(pairs, [2]string{"host", "web 1"})
+	})
+	if want := "a=1 host=\"web 1\"\nb=2 host=\"web 1\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTranscoderDropRecord(t *testing.T) {
+	got := transcode(t, "level=debug a=1\nlevel=info b=2\n", func(pairs [][2]string) [][2]string {
+		if matchAll(pairs, []condition{{key: "level", value: "debug"}}) {
+			return nil
+		}
+		return pairs
+	})
+	if want := "level=info b=2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTranscoderError(t *testing.T) {
+	var out bytes.Buffer
+	tc := newTranscoder(strings.NewReader("a=1\nb=\"2\n"), &out, func(pairs [][2]string) [][2]string { return pairs })
+
+	err := tc.Run()
+	if want := `logfmt syntax error at pos 3 on line 2: unterminated quoted value for key "b"`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+	if want := "a=1\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestTranscoderLimits(t *testing.T) {
+	for _, set := range []func(*transcoder){
+		func(tc *transcoder) { tc.maxLine = 0 },
+		func(tc *transcoder) { tc.maxLine = -1 },
+		func(tc *transcoder) { tc.bufSize = -1 },
+	} {
+		tc := newTranscoder(strings.NewReader("a=1\n"), io.Discard, func(pairs [][2]string) [][2]string { return pairs })
+		set(tc)
+		if err := tc.Run(); err == nil {
+			t.Errorf("maxLine %d, bufSize %d: no error", tc.maxLine, tc.bufSize)
+		}
+	}
+}
+
+func BenchmarkBufSize(b *testing.B) {
+	for _, lineLen := range []int{100, 8 << 10} {
+		line := "msg=" + strings.Repeat("x", lineLen-len("msg=\n")) + "\n"
+		input := strings.Repeat(line, 100)
+
+		for _, bufSize := range []int{4 << 10, 16 << 10} {
+			b.Run(fmt.Sprintf("line=%d/buf=%d", lineLen, bufSize), func(b *testing.B) {
+				args := []string{"-buf-size", fmt.Sprint(bufSize)}
+				b.ReportAllocs()
+				b.SetBytes(int64(len(input)))
+				for i := 0; i < b.N; i++ {
+					run(context.Background(), args, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{})
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// transforms holds the record transformations selected on the command
+// line. Its apply method is the transform run passes to the transcoder.
+type transforms struct {
+	lowerKeys  bool
+	replacer   *strings.Replacer
+	renames    renameList
+	duplicates string
+	where      []condition
+	include    keyList
+	exclude    keyList
+	omitEmpty  bool
+	dropEmpty  bool
+
+	addTS          bool
+	tsKey          string
+	forceTS        bool
+	maxValueLen    int
+	truncateMarker string
+	sortKeys       bool
+	reverse        bool
+}
+
+// apply renames, filters and rewrites the pairs of a record, in that order.
+// It returns nil if the record is filtered out.
+func (t *transforms) apply(pairs [][2]string) [][2]string {
+	if t.lowerKeys || t.replacer != nil {
+		normalizeKeys(pairs, t.lowerKeys, t.replacer)
+	}
+	pairs = renameKeys(pairs, t.renames)
+	pairs = dedupeKeys(pairs, t.duplicates)
+
+	if !matchAll(pairs, t.where) {
+		return nil
+	}
+
+	pairs = filterKeys(pairs, t.include, t.exclude)
+	if t.omitEmpty {
+		pairs = omitEmpty(pairs)
+	}
+	if t.dropEmpty && len(pairs) == 0 {
+		return nil
+	}
+
+	if t.addTS {
+		pairs = addTimestamp(pairs, t.tsKey, now().Format(time.RFC3339), t.forceTS)
+	}
+
+	truncateValues(pairs, t.maxValueLen, t.truncateMarker)
+
+	if t.sortKeys {
+		// A stable sort keeps repeated keys in their original order.
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return pairs[i][0] < pairs[j][0]
+		})
+	}
+	if t.reverse {
+		slices.Reverse(pairs)
+	}
+	return pairs
+}
+
+// truncateValues shortens values longer than max bytes to at most max bytes
+// followed by marker. The cut is moved back to a rune boundary so multibyte
+// characters are never split. A max of zero disables truncation.
+func truncateValues(pairs [][2]string, max int, marker string) {
+	if max <= 0 {
+		return
+	}
+	for i, kv := range pairs {
+		value := kv[1]
+		if len(value) <= max {
+			continue
+		}
+		n := max
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+		pairs[i][1] = value[:n] + marker
+	}
+}
+
+// renameList is the value of the repeatable -rename flag, mapping old keys
+// to new ones.
+type renameList map[string]string
+
+func (l renameList) String() string {
+	renames := make([]string, 0, len(l))
+	for from, to := range l {
+		renames = append(renames, from+"="+to)
+	}
+	sort.Strings(renames)
+	return strings.Join(renames, ",")
+}
+
+func (l renameList) Set(s string) error {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" || to == "" {
+		return errors.New("must be of the form old=new")
+	}
+	if !validKey(to) {
+		return fmt.Errorf("%q is not a valid logfmt key", to)
+	}
+	l[from] = to
+	return nil
+}
+
+// renameKeys renames the keys of pairs according to renames, keeping values
+// and order. If a renamed key collides with another pair of the record, the
+// later pair wins and the earlier one is dropped.
+func renameKeys(pairs [][2]string, renames renameList) [][2]string {
+	if len(renames) == 0 {
+		return pairs
+	}
+
+	var renamed map[string]bool
+	for i, kv := range pairs {
+		if to, ok := renames[kv[0]]; ok {
+			pairs[i][0] = to
+			if renamed == nil {
+				renamed = make(map[string]bool)
+			}
+			renamed[to] = true
+		}
+	}
+	if renamed == nil {
+		return pairs
+	}
+
+	last := make(map[string]int, len(renamed))
+	for i, kv := range pairs {
+		if renamed[kv[0]] {
+			last[kv[0]] = i
+		}
+	}
+	kept := pairs[:0]
+	for i, kv := range pairs {
+		if !renamed[kv[0]] || last[kv[0]] == i {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// addTimestamp prepends a pair of key and ts to pairs. A record that already
+// holds key is left alone unless force is set, in which case the existing
+// pairs for key are dropped in favor of the new one.
+func addTimestamp(pairs [][2]string, key, ts string, force bool) [][2]string {
+	stamped := make([][2]string, 1, len(pairs)+1)
+	stamped[0] = [2]string{key, ts}
+	for _, kv := range pairs {
+		if kv[0] != key {
+			stamped = append(stamped, kv)
+		} else if !force {
+			return pairs
+		}
+	}
+	return stamped
+}
+
+// replaceList is the value of the repeatable -key-replace flag, holding
+// old, new string pairs in the form expected by strings.NewReplacer.
+type replaceList []string
+
+func (l *replaceList) String() string { return "" }
+
+func (l *replaceList) Set(s string) error {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" {
+		return errors.New("must be of the form old=new")
+	}
+	// The replacement may be empty, which deletes every occurrence of old.
+	if to != "" && !validKey(to) {
+		return fmt.Errorf("%q is not a valid logfmt key", to)
+	}
+	*l = append(*l, from, to)
+	return nil
+}
+
+// normalizeKeys lowercases keys if lower is set and then applies replacer,
+// if any. Values are left untouched.
+func normalizeKeys(pairs [][2]string, lower bool, replacer *strings.Replacer) {
+	for i, kv := range pairs {
+		if lower {
+			kv[0] = strings.ToLower(kv[0])
+		}
+		if replacer != nil {
+			kv[0] = replacer.Replace(kv[0])
+		}
+		pairs[i] = kv
+	}
+}
+
+// dedupeKeys drops repeated keys from pairs according to strategy: "first"
+// keeps the first pair for each key and "last" the last one, each at its
+// own position. Any other strategy, such as "all", keeps every pair.
+func dedupeKeys(pairs [][2]string, strategy string) [][2]string {
+	if strategy != "first" && strategy != "last" {
+		return pairs
+	}
+
+	index := make(map[string]int, len(pairs))
+	for i, kv := range pairs {
+		if _, seen := index[kv[0]]; !seen || strategy == "last" {
+			index[kv[0]] = i
+		}
+	}
+	kept := pairs[:0]
+	for i, kv := range pairs {
+		if index[kv[0]] == i {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTruncateValues(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{[]string{"-max-value-len", "5"}, "msg=abcdefgh short=abc", "msg=abcde… short=abc"},
+		{[]string{"-max-value-len", "5"}, "exact=abcde", "exact=abcde"},
+		{[]string{"-max-value-len", "5", "-truncate-marker", "..."}, "msg=abcdefgh", "msg=abcde..."},
+		{[]string{"-max-value-len", "5", "-truncate-marker", " [cut]"}, "msg=abcdefgh", `msg="abcde [cut]"`},
+		{[]string{"-max-value-len", "9"}, `msg="stack trace follows"`, `msg="stack tra…"`},
+		// "ü" is two bytes and "🌍" four, straddling the limit.
+		{[]string{"-max-value-len", "4"}, "msg=abcü", "msg=abc…"},
+		{[]string{"-max-value-len", "5"}, "msg=abcü", "msg=abcü"},
+		{[]string{"-max-value-len", "5"}, "msg=ab🌍cd", "msg=ab…"},
+		{[]string{"-max-value-len", "6"}, "msg=ab🌍cd", "msg=ab🌍…"},
+		{[]string{"-max-value-len", "3"}, "msg=🌍🌍", "msg=…"},
+		{nil, "msg=abcdefgh", "msg=abcdefgh"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("%v %q: got %q, want %q", tt.args, tt.input, stdout, tt.want+"\n")
+		}
+	}
+
+	if _, _, code := echo(t, []string{"-max-value-len", "-1"}, "a=1\n"); code != 2 {
+		t.Errorf("-max-value-len -1: exit code %d, want 2", code)
+	}
+}
+
+func TestRenameKeys(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{[]string{"-rename", "msg=message"}, "at=INFO msg=hello user=5", "at=INFO message=hello user=5"},
+		{[]string{"-rename", "msg=message", "-rename", "at=level"}, "at=INFO msg=hello", "level=INFO message=hello"},
+		{[]string{"-rename", "msg=message"}, "message=old at=INFO msg=new", "at=INFO message=new"},
+		{[]string{"-rename", "msg=message"}, "msg=new at=INFO message=old", "at=INFO message=old"},
+		{[]string{"-rename", "msg=message", "-rename", "text=message"}, "msg=a text=b", "message=b"},
+		{[]string{"-rename", "a=b", "-rename", "b=c"}, "a=1 b=2", "b=1 c=2"},
+		{[]string{"-rename", "msg=message"}, "tag=x tag=y", "tag=x tag=y"},
+		{[]string{"-rename", "msg=message", "-where", "message=hi"}, "msg=hi", "message=hi"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("%v %q: got %q, want %q", tt.args, tt.input, stdout, tt.want+"\n")
+		}
+	}
+}
+
+func TestRenameInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-rename", "msg"},
+		{"-rename", "=x"},
+		{"-rename", "x="},
+		{"-rename", "msg=a b"},
+		{"-rename", `msg=a"b`},
+	} {
+		if _, _, code := echo(t, args, ""); code != 2 {
+			t.Errorf("%v: exit code %d, want 2", args, code)
+		}
+	}
+}
+
+func TestAddTimestamp(t *testing.T) {
+	defer func(prev func() time.Time) { now = prev }(now)
+	now = func() time.Time {
+		return time.Date(2025, 7, 30, 12, 34, 56, 789, time.FixedZone("CEST", 2*60*60))
+	}
+
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{[]string{"-add-ts"}, "msg=hello", "ts=2025-07-30T12:34:56+02:00 msg=hello"},
+		{[]string{"-add-ts"}, "", "ts=2025-07-30T12:34:56+02:00"},
+		{[]string{"-add-ts"}, "msg=hello ts=old", "msg=hello ts=old"},
+		{[]string{"-add-ts", "-force-ts"}, "msg=hello ts=old ts=older", "ts=2025-07-30T12:34:56+02:00 msg=hello"},
+		{[]string{"-add-ts", "-ts-key", "time"}, "msg=hello ts=old", "time=2025-07-30T12:34:56+02:00 msg=hello ts=old"},
+		{[]string{"-add-ts", "-exclude", "ts"}, "ts=old msg=hello", "ts=2025-07-30T12:34:56+02:00 msg=hello"},
+		{[]string{"-ts-key", "time"}, "msg=hello", "msg=hello"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("%v %q: got %q, want %q", tt.args, tt.input, stdout, tt.want+"\n")
+		}
+	}
+
+	for _, key := range []string{"", "a b", "a=b"} {
+		if _, _, code := echo(t, []string{"-add-ts", "-ts-key", key}, ""); code != 2 {
+			t.Errorf("-ts-key %q: exit code %d, want 2", key, code)
+		}
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		input, want string
+	}{
+		{[]string{"-lower-keys"}, "Level=INFO LEVEL=Warn msg=Hello", "level=INFO level=Warn msg=Hello"},
+		{[]string{"-key-replace", ".=_"}, "user.id=5 url=a.b.c", "user_id=5 url=a.b.c"},
+		{[]string{"-key-replace", ".=_", "-key-replace", "-="}, "user.first-name=Ann", "user_firstname=Ann"},
+		{[]string{"-lower-keys", "-key-replace", "ID=identifier"}, "userID=5", "userid=5"},
+		{[]string{"-key-replace", "ID=identifier", "-lower-keys"}, "userID=5", "userid=5"},
+		{[]string{"-lower-keys", "-rename", "message=msg"}, "Message=Hello", "msg=Hello"},
+	} {
+		stdout, stderr, code := echo(t, tt.args, tt.input+"\n")
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want+"\n" {
+			t.Errorf("%v %q: got %q, want %q", tt.args, tt.input, stdout, tt.want+"\n")
+		}
+	}
+
+	if _, _, code := echo(t, []string{"-key-replace", "_= "}, ""); code != 2 {
+		t.Errorf("-key-replace with a space: exit code %d, want 2", code)
+	}
+
+	// A key left empty cannot be written.
+	for _, args := range [][]string{{"-key-replace", "a="}, {"-key-replace", "a=", "-pretty"}} {
+		_, stderr, code := echo(t, args, "a=1\n")
+		if code != 1 {
+			t.Errorf("%v: exit code %d, want 1", args, code)
+		}
+		if want := "error: line 1: invalid key \"\"\n"; stderr != want {
+			t.Errorf("%v: got %q, want %q", args, stderr, want)
+		}
+	}
+}
+
+func TestDedupeKeys(t *testing.T) {
+	input := "tag=a at=INFO tag=b tag=c\n"
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "tag=a at=INFO tag=b tag=c\n"},
+		{[]string{"-duplicates", "all"}, "tag=a at=INFO tag=b tag=c\n"},
+		{[]string{"-duplicates", "first"}, "tag=a at=INFO\n"},
+		{[]string{"-duplicates", "last"}, "at=INFO tag=c\n"},
+		{[]string{"-duplicates", "all", "-to", "json"}, `{"at":"INFO","tag":["a","b","c"]}` + "\n"},
+		{[]string{"-duplicates", "first", "-to", "json"}, `{"at":"INFO","tag":"a"}` + "\n"},
+		{[]string{"-duplicates", "last", "-to", "json"}, `{"at":"INFO","tag":"c"}` + "\n"},
+		{[]string{"-duplicates", "first", "-where", "tag=c"}, ""},
+	} {
+		stdout, stderr, code := echo(t, tt.args, input)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.args, code, stderr)
+		}
+		if stdout != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, stdout, tt.want)
+		}
+	}
+
+	if _, _, code := echo(t, []string{"-duplicates", "none"}, input); code != 2 {
+		t.Errorf("exit code %d, want 2", code)
+	}
+}